@@ -0,0 +1,132 @@
+package main
+
+import "fmt"
+
+// Bus is the interface a memory-mapped device answers load/store
+// instructions through. Plain RAM and every MMIO device implement it the
+// same way, so the cpu never needs to know which one it is talking to.
+type Bus interface {
+	Read8(addr uint32) uint8
+	Read16(addr uint32) uint16
+	Read32(addr uint32) uint32
+	Write8(addr uint32, val uint8)
+	Write16(addr uint32, val uint16)
+	Write32(addr uint32, val uint32)
+}
+
+// ram is a flat little-endian Bus backed by a byte slice.
+type ram []byte
+
+func (m ram) Read8(addr uint32) uint8 { return m[addr] }
+
+func (m ram) Read16(addr uint32) uint16 {
+	return uint16(m[addr]) | uint16(m[addr+1])<<8
+}
+
+func (m ram) Read32(addr uint32) uint32 {
+	return uint32(m[addr]) | uint32(m[addr+1])<<8 | uint32(m[addr+2])<<16 | uint32(m[addr+3])<<24
+}
+
+func (m ram) Write8(addr uint32, val uint8) { m[addr] = val }
+
+func (m ram) Write16(addr uint32, val uint16) {
+	m[addr] = byte(val)
+	m[addr+1] = byte(val >> 8)
+}
+
+func (m ram) Write32(addr uint32, val uint32) {
+	for i := 0; i < 4; i++ {
+		m[addr+uint32(i)] = byte(val >> uint(8*i))
+	}
+}
+
+// mapping is one device mounted onto a systemBus, answering for
+// [base, base+size).
+type mapping struct {
+	base uint32
+	size uint32
+	dev  Bus
+}
+
+func (m mapping) contains(addr uint32) bool {
+	return addr >= m.base && addr < m.base+m.size
+}
+
+// systemBus is the cpu's full address space: flat ram plus whatever MMIO
+// devices are mounted over it. A load or store checks the mounted devices
+// before falling back to ram, so a device can sit at any address without
+// ram needing to know about it.
+type systemBus struct {
+	ram      ram
+	mappings []mapping
+}
+
+// newSystemBus returns a systemBus backed by mem.
+func newSystemBus(mem []byte) *systemBus {
+	return &systemBus{ram: ram(mem)}
+}
+
+// mount registers dev to answer for [base, base+size), taking priority
+// over ram. It panics on overlap with an already-mounted device, since
+// that is always a configuration mistake rather than something to run
+// with.
+func (b *systemBus) mount(base, size uint32, dev Bus) {
+	for _, m := range b.mappings {
+		if base < m.base+m.size && m.base < base+size {
+			panic(fmt.Sprintf("mmio range %#x-%#x overlaps existing mapping %#x-%#x", base, base+size, m.base, m.base+m.size))
+		}
+	}
+	b.mappings = append(b.mappings, mapping{base: base, size: size, dev: dev})
+}
+
+// find returns the device answering for addr, along with the base to
+// subtract so the device sees an offset relative to its own mapping
+// rather than the cpu's absolute address. ram is its own base-0 mapping,
+// since it already indexes by absolute address.
+func (b *systemBus) find(addr uint32) (dev Bus, base uint32) {
+	for _, m := range b.mappings {
+		if m.contains(addr) {
+			return m.dev, m.base
+		}
+	}
+	return b.ram, 0
+}
+
+func (b *systemBus) Read8(addr uint32) uint8 {
+	dev, base := b.find(addr)
+	return dev.Read8(addr - base)
+}
+
+func (b *systemBus) Read16(addr uint32) uint16 {
+	dev, base := b.find(addr)
+	return dev.Read16(addr - base)
+}
+
+func (b *systemBus) Read32(addr uint32) uint32 {
+	dev, base := b.find(addr)
+	return dev.Read32(addr - base)
+}
+
+func (b *systemBus) Write8(addr uint32, val uint8) {
+	dev, base := b.find(addr)
+	dev.Write8(addr-base, val)
+}
+
+func (b *systemBus) Write16(addr uint32, val uint16) {
+	dev, base := b.find(addr)
+	dev.Write16(addr-base, val)
+}
+
+func (b *systemBus) Write32(addr uint32, val uint32) {
+	dev, base := b.find(addr)
+	dev.Write32(addr-base, val)
+}
+
+// RAM exposes the backing store directly, for the venus syscall ABI and
+// the debugger's memory examiner, which want to walk a contiguous byte
+// range rather than go through individual word accesses. It only ever
+// addresses ram, never a mounted device.
+func (b *systemBus) RAM() []byte { return b.ram }
+
+// Len reports the size of the ram backing store.
+func (b *systemBus) Len() int { return len(b.ram) }
@@ -0,0 +1,77 @@
+package main
+
+// CLINT (core-local interruptor) base address and register layout,
+// following the same offsets used by SiFive's implementation and by
+// QEMU's "virt" machine: a single-hart mtimecmp low/high pair, and a free
+// running mtime low/high pair further up the range.
+const (
+	clintBase = 0x02000000
+	clintSize = 0x10000
+
+	clintMtimecmpLo = 0x4000
+	clintMtimecmpHi = 0x4004
+	clintMtimeLo    = 0xbff8
+	clintMtimeHi    = 0xbffc
+)
+
+// clint is a minimal single-hart CLINT: a free-running mtime counter,
+// ticked once per retired instruction, and an mtimecmp comparator that
+// fires a machine timer interrupt once mtime reaches it.
+type clint struct {
+	mtime    uint64
+	mtimecmp uint64
+}
+
+func newCLINT() *clint {
+	return &clint{}
+}
+
+// tick advances mtime by one, approximating time passing as instructions
+// retire.
+func (c *clint) tick() {
+	c.mtime++
+}
+
+// pending reports whether the timer comparator has fired. mtimecmp
+// defaults to zero, which would otherwise fire immediately, so a
+// zero comparator is treated as "never armed" until software sets it.
+func (c *clint) pending() bool {
+	return c.mtimecmp != 0 && c.mtime >= c.mtimecmp
+}
+
+func (c *clint) Read32(addr uint32) uint32 {
+	switch addr {
+	case clintMtimecmpLo:
+		return uint32(c.mtimecmp)
+	case clintMtimecmpHi:
+		return uint32(c.mtimecmp >> 32)
+	case clintMtimeLo:
+		return uint32(c.mtime)
+	case clintMtimeHi:
+		return uint32(c.mtime >> 32)
+	default:
+		return 0
+	}
+}
+
+func (c *clint) Write32(addr uint32, val uint32) {
+	switch addr {
+	case clintMtimecmpLo:
+		c.mtimecmp = c.mtimecmp&0xffffffff00000000 | uint64(val)
+	case clintMtimecmpHi:
+		c.mtimecmp = c.mtimecmp&0xffffffff | uint64(val)<<32
+	case clintMtimeLo:
+		c.mtime = c.mtime&0xffffffff00000000 | uint64(val)
+	case clintMtimeHi:
+		c.mtime = c.mtime&0xffffffff | uint64(val)<<32
+	}
+}
+
+func (c *clint) Read8(addr uint32) uint8   { return uint8(c.Read32(addr)) }
+func (c *clint) Read16(addr uint32) uint16 { return uint16(c.Read32(addr)) }
+
+// Write8 and Write16 are no-ops: the CLINT's registers are conventionally
+// only ever touched with aligned word stores, and a sub-word store here
+// would clobber half a register with no way to preserve the other half.
+func (c *clint) Write8(addr uint32, val uint8)   {}
+func (c *clint) Write16(addr uint32, val uint16) {}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Glorforidor/caeriscv/decode"
+)
+
+// cpu bundles the machine state that execute and its trap/syscall helpers
+// need to thread through: registers, the memory-mapped address space, the
+// program counter, the CSR file, and the bits of OS-like state (heap
+// break, open files) the Venus syscall ABI requires.
+type cpu struct {
+	Reg [32]uint32
+	Mem *systemBus
+	PC  uint32
+	CSR csrFile
+
+	timer *clint
+	exit  *hostExit
+
+	brk    uint32
+	files  map[uint32]*os.File
+	nextFd uint32
+}
+
+// newCPU returns a cpu ready to run mem starting at pc, with the stack
+// pointer (x2) set to sp. It mounts the CLINT timer, the UART and the
+// host-exit word at their fixed addresses over mem.
+func newCPU(mem []byte, pc, sp uint32) *cpu {
+	bus := newSystemBus(mem)
+
+	timer := newCLINT()
+	exit := newHostExit()
+	bus.mount(clintBase, clintSize, timer)
+	bus.mount(uartBase, uartSize, newUART())
+	bus.mount(hostExitBase, hostExitSize, exit)
+
+	c := &cpu{
+		Mem:   bus,
+		PC:    pc,
+		CSR:   newCSRFile(),
+		timer: timer,
+		exit:  exit,
+		brk:   uint32(len(mem)),
+		files: map[uint32]*os.File{
+			0: os.Stdin,
+			1: os.Stdout,
+			2: os.Stderr,
+		},
+		nextFd: 3,
+	}
+	c.Reg[2] = sp
+	return c
+}
+
+// checkTimerInterrupt raises a machine timer interrupt if the CLINT
+// comparator has fired and the cpu has interrupts enabled, redirecting to
+// mtvec exactly like a synchronous trap. It reports the pc offset to jump
+// by and whether an interrupt was taken.
+func (c *cpu) checkTimerInterrupt() (offset int, taken bool) {
+	if !c.timer.pending() {
+		return 0, false
+	}
+	if c.CSR.read(csrMstatus)&mstatusMIEBit == 0 || c.CSR.read(csrMie)&mtimerBit == 0 {
+		return 0, false
+	}
+	mtvec := c.CSR.read(csrMtvec)
+	if mtvec == 0 {
+		return 0, false
+	}
+
+	c.CSR.write(csrMepc, c.PC)
+	c.CSR.write(csrMcause, mcauseInterruptBit|causeMachineTimerInterrupt)
+	c.CSR.write(csrMtval, 0)
+	c.enterTrap()
+	return int(mtvec) - int(c.PC), true
+}
+
+// enterTrap saves the current mstatus.MIE into mstatus.MPIE and clears MIE,
+// per the privileged spec's trap entry sequence, so mret can restore the
+// interrupt-enable state the trap interrupted.
+func (c *cpu) enterTrap() {
+	mstatus := c.CSR.read(csrMstatus)
+	mstatus &^= mstatusMPIEBit
+	if mstatus&mstatusMIEBit != 0 {
+		mstatus |= mstatusMPIEBit
+	}
+	mstatus &^= mstatusMIEBit
+	c.CSR.write(csrMstatus, mstatus)
+}
+
+// mret services an "mret" instruction: it returns from a trap by jumping
+// to mepc and restoring mstatus.MIE from the MPIE saved at trap entry.
+func (c *cpu) mret() (offset int, branching bool) {
+	mstatus := c.CSR.read(csrMstatus)
+	if mstatus&mstatusMPIEBit != 0 {
+		mstatus |= mstatusMIEBit
+	} else {
+		mstatus &^= mstatusMIEBit
+	}
+	mstatus |= mstatusMPIEBit
+	c.CSR.write(csrMstatus, mstatus)
+
+	mepc := c.CSR.read(csrMepc)
+	return int(mepc) - int(c.PC), true
+}
+
+// execute decodes and executes the instruction and stores the results
+// into the registers. It will return whether a branch instruction is
+// taken with an offset.
+func (c *cpu) execute(instr uint32) (offset int, branching, exit bool) {
+	inst := decode.Decode(instr)
+	reg := c.Reg[:]
+
+	switch inst.Op {
+	case decode.LB, decode.LH, decode.LW, decode.LBU, decode.LHU:
+		addr := reg[inst.Rs1] + uint32(inst.Imm)
+		if loadMisaligned(inst.Op, addr) {
+			return c.trap(causeLoadAddrMisaligned, addr)
+		}
+		execLoad(inst, reg, c.Mem)
+	case decode.ADDI, decode.SLLI, decode.SLTI, decode.SLTIU, decode.XORI,
+		decode.SRLI, decode.SRAI, decode.ORI, decode.ANDI:
+		execImm(inst, reg)
+	case decode.AUIPC:
+		reg[inst.Rd] = c.PC + uint32(inst.Imm)
+	case decode.SB, decode.SH, decode.SW:
+		addr := reg[inst.Rs1] + uint32(inst.Imm)
+		if storeMisaligned(inst.Op, addr) {
+			return c.trap(causeStoreAddrMisaligned, addr)
+		}
+		execStore(inst, reg, c.Mem)
+	case decode.ADD, decode.MUL, decode.SUB, decode.SLL, decode.MULH,
+		decode.SLT, decode.MULHSU, decode.SLTU, decode.MULHU, decode.XOR,
+		decode.DIV, decode.SRL, decode.DIVU, decode.SRA, decode.OR,
+		decode.REM, decode.AND, decode.REMU:
+		execReg(inst, reg)
+	case decode.LUI:
+		reg[inst.Rd] = uint32(inst.Imm)
+	case decode.BEQ, decode.BNE, decode.BLT, decode.BGE, decode.BLTU, decode.BGEU:
+		offset, branching = execBranch(inst, reg)
+	case decode.JALR:
+		target := (reg[inst.Rs1] + uint32(inst.Imm)) & 0xfffffffe
+		reg[inst.Rd] = c.PC + 4
+		offset = int(target) - int(c.PC)
+		branching = true
+	case decode.JAL:
+		reg[inst.Rd] = c.PC + 4
+		offset = int(inst.Imm)
+		branching = true
+	case decode.CSRRW, decode.CSRRS, decode.CSRRC, decode.CSRRWI, decode.CSRRSI, decode.CSRRCI:
+		execCSR(inst, reg, &c.CSR)
+	case decode.ECALL:
+		offset, branching, exit = c.ecall()
+	case decode.EBREAK:
+		offset, branching, exit = c.ebreak()
+	case decode.MRET:
+		offset, branching = c.mret()
+	default:
+		return c.trap(causeIllegalInstruction, instr)
+	}
+
+	reg[0] = 0
+
+	return offset, branching, exit
+}
+
+// ecall services an "ecall" instruction. Programs that never install a
+// trap handler (the common case for Venus-style assembly) get the
+// syscall serviced inline, keyed on a7, without ever leaving the
+// instruction stream. Programs that do set mtvec get a real trap instead,
+// so they can implement their own environment-call handling.
+func (c *cpu) ecall() (offset int, branching, exit bool) {
+	c.CSR.write(csrMepc, c.PC)
+	c.CSR.write(csrMcause, causeEcallM)
+	c.CSR.write(csrMtval, 0)
+
+	if mtvec := c.CSR.read(csrMtvec); mtvec != 0 {
+		c.enterTrap()
+		return int(mtvec) - int(c.PC), true, false
+	}
+
+	return 0, false, c.syscall()
+}
+
+// ebreak services an "ebreak" instruction: it traps through mtvec if a
+// handler is installed, otherwise it halts the simulator like a debugger
+// breakpoint with no debugger attached.
+func (c *cpu) ebreak() (offset int, branching, exit bool) {
+	c.CSR.write(csrMepc, c.PC)
+	c.CSR.write(csrMcause, causeBreakpoint)
+	c.CSR.write(csrMtval, 0)
+
+	if mtvec := c.CSR.read(csrMtvec); mtvec != 0 {
+		c.enterTrap()
+		return int(mtvec) - int(c.PC), true, false
+	}
+
+	fmt.Println("ebreak: no trap handler installed, halting")
+	return 0, false, true
+}
+
+// trap raises cause with the given trap value: it records the trapping pc
+// and cause/tval CSRs and redirects control to mtvec. If no handler is
+// installed (mtvec is still zero), the trap is fatal.
+func (c *cpu) trap(cause, tval uint32) (offset int, branching, exit bool) {
+	c.CSR.write(csrMepc, c.PC)
+	c.CSR.write(csrMcause, cause)
+	c.CSR.write(csrMtval, tval)
+
+	mtvec := c.CSR.read(csrMtvec)
+	if mtvec == 0 {
+		fmt.Printf("fatal trap: cause=%d tval=%#x pc=%#x\n", cause, tval, c.PC)
+		return 0, false, true
+	}
+
+	c.enterTrap()
+	return int(mtvec) - int(c.PC), true, false
+}
+
+// loadMisaligned reports whether addr is misaligned for a load of op's size.
+func loadMisaligned(op decode.Op, addr uint32) bool {
+	var size uint32 = 1
+	switch op {
+	case decode.LH, decode.LHU:
+		size = 2
+	case decode.LW:
+		size = 4
+	}
+	return addr%size != 0
+}
+
+// storeMisaligned reports whether addr is misaligned for a store of op's size.
+func storeMisaligned(op decode.Op, addr uint32) bool {
+	var size uint32 = 1
+	switch op {
+	case decode.SH:
+		size = 2
+	case decode.SW:
+		size = 4
+	}
+	return addr%size != 0
+}
+
+// execLoad handles LB, LH, LW, LBU and LHU.
+func execLoad(inst decode.Inst, reg []uint32, mem Bus) {
+	addr := reg[inst.Rs1] + uint32(inst.Imm)
+	switch inst.Op {
+	case decode.LB:
+		reg[inst.Rd] = uint32(int8(mem.Read8(addr)))
+	case decode.LH:
+		reg[inst.Rd] = uint32(int16(mem.Read16(addr)))
+	case decode.LW:
+		reg[inst.Rd] = mem.Read32(addr)
+	case decode.LBU:
+		reg[inst.Rd] = uint32(mem.Read8(addr))
+	case decode.LHU:
+		reg[inst.Rd] = uint32(mem.Read16(addr))
+	}
+}
+
+// execImm handles the register-immediate ALU ops.
+func execImm(inst decode.Inst, reg []uint32) {
+	imm := uint32(inst.Imm)
+	rs1 := reg[inst.Rs1]
+	switch inst.Op {
+	case decode.ADDI:
+		reg[inst.Rd] = rs1 + imm
+	case decode.SLLI:
+		reg[inst.Rd] = rs1 << (imm & 0x1f)
+	case decode.SLTI:
+		if int32(rs1) < inst.Imm {
+			reg[inst.Rd] = 1
+		} else {
+			reg[inst.Rd] = 0
+		}
+	case decode.SLTIU:
+		if rs1 < imm {
+			reg[inst.Rd] = 1
+		} else {
+			reg[inst.Rd] = 0
+		}
+	case decode.XORI:
+		reg[inst.Rd] = rs1 ^ imm
+	case decode.SRLI:
+		reg[inst.Rd] = rs1 >> (imm & 0x1f)
+	case decode.SRAI:
+		reg[inst.Rd] = uint32(int32(rs1) >> (imm & 0x1f))
+	case decode.ORI:
+		reg[inst.Rd] = rs1 | imm
+	case decode.ANDI:
+		reg[inst.Rd] = rs1 & imm
+	}
+}
+
+// execStore handles SB, SH and SW.
+func execStore(inst decode.Inst, reg []uint32, mem Bus) {
+	addr := reg[inst.Rs1] + uint32(inst.Imm)
+	switch inst.Op {
+	case decode.SB:
+		mem.Write8(addr, uint8(reg[inst.Rs2]))
+	case decode.SH:
+		mem.Write16(addr, uint16(reg[inst.Rs2]))
+	case decode.SW:
+		mem.Write32(addr, reg[inst.Rs2])
+	}
+}
+
+// execReg handles the register-register ALU and M-extension ops.
+func execReg(inst decode.Inst, reg []uint32) {
+	rs1, rs2 := reg[inst.Rs1], reg[inst.Rs2]
+	switch inst.Op {
+	case decode.ADD:
+		reg[inst.Rd] = rs1 + rs2
+	case decode.MUL:
+		reg[inst.Rd] = rs1 * rs2
+	case decode.SUB:
+		reg[inst.Rd] = rs1 - rs2
+	case decode.SLL:
+		reg[inst.Rd] = rs1 << (rs2 & 0x1f)
+	case decode.MULH:
+		reg[inst.Rd] = uint32((int64(int32(rs1)) * int64(int32(rs2))) >> 32)
+	case decode.SLT:
+		if int32(rs1) < int32(rs2) {
+			reg[inst.Rd] = 1
+		} else {
+			reg[inst.Rd] = 0
+		}
+	case decode.MULHSU:
+		reg[inst.Rd] = uint32((int64(int32(rs1)) * int64(rs2)) >> 32)
+	case decode.SLTU:
+		if rs1 < rs2 {
+			reg[inst.Rd] = 1
+		} else {
+			reg[inst.Rd] = 0
+		}
+	case decode.MULHU:
+		reg[inst.Rd] = uint32((uint64(rs1) * uint64(rs2)) >> 32)
+	case decode.XOR:
+		reg[inst.Rd] = rs1 ^ rs2
+	case decode.DIV:
+		if int32(rs2) == 0 {
+			reg[inst.Rd] = ^uint32(0)
+		} else {
+			reg[inst.Rd] = uint32(int32(rs1) / int32(rs2))
+		}
+	case decode.SRL:
+		reg[inst.Rd] = rs1 >> (rs2 & 0x1f)
+	case decode.DIVU:
+		// TODO: ask TA about unsigned division by zero.
+		if rs2 == 0 {
+			reg[inst.Rd] = rs1
+		} else {
+			reg[inst.Rd] = rs1 / rs2
+		}
+	case decode.SRA:
+		reg[inst.Rd] = uint32(int32(rs1) >> (rs2 & 0x1f))
+	case decode.OR:
+		reg[inst.Rd] = rs1 | rs2
+	case decode.REM:
+		if rs2 == 0 {
+			reg[inst.Rd] = rs1
+		} else {
+			reg[inst.Rd] = uint32(int32(rs1) % int32(rs2))
+		}
+	case decode.AND:
+		reg[inst.Rd] = rs1 & rs2
+	case decode.REMU:
+		if rs2 == 0 {
+			reg[inst.Rd] = rs1
+		} else {
+			reg[inst.Rd] = rs1 % rs2
+		}
+	}
+}
+
+// execBranch handles BEQ, BNE, BLT, BGE, BLTU and BGEU. It returns the
+// byte offset to the branch target and whether the branch is taken.
+func execBranch(inst decode.Inst, reg []uint32) (offset int, taken bool) {
+	rs1, rs2 := reg[inst.Rs1], reg[inst.Rs2]
+	switch inst.Op {
+	case decode.BEQ:
+		taken = rs1 == rs2
+	case decode.BNE:
+		taken = rs1 != rs2
+	case decode.BLT:
+		taken = int32(rs1) < int32(rs2)
+	case decode.BGE:
+		taken = int32(rs1) >= int32(rs2)
+	case decode.BLTU:
+		taken = rs1 < rs2
+	case decode.BGEU:
+		taken = rs1 >= rs2
+	}
+	return int(inst.Imm), taken
+}
+
+// execCSR handles CSRRW, CSRRS, CSRRC and their immediate forms.
+func execCSR(inst decode.Inst, reg []uint32, csr *csrFile) {
+	switch inst.Op {
+	case decode.CSRRW:
+		var old uint32
+		if inst.Rd != 0 {
+			old = csr.read(inst.Csr)
+		}
+		csr.write(inst.Csr, reg[inst.Rs1])
+		reg[inst.Rd] = old
+	case decode.CSRRS:
+		old := csr.read(inst.Csr)
+		if inst.Rs1 != 0 {
+			csr.write(inst.Csr, old|reg[inst.Rs1])
+		}
+		reg[inst.Rd] = old
+	case decode.CSRRC:
+		old := csr.read(inst.Csr)
+		if inst.Rs1 != 0 {
+			csr.write(inst.Csr, old&^reg[inst.Rs1])
+		}
+		reg[inst.Rd] = old
+	case decode.CSRRWI:
+		var old uint32
+		if inst.Rd != 0 {
+			old = csr.read(inst.Csr)
+		}
+		csr.write(inst.Csr, inst.Rs1)
+		reg[inst.Rd] = old
+	case decode.CSRRSI:
+		old := csr.read(inst.Csr)
+		if inst.Rs1 != 0 {
+			csr.write(inst.Csr, old|inst.Rs1)
+		}
+		reg[inst.Rd] = old
+	case decode.CSRRCI:
+		old := csr.read(inst.Csr)
+		if inst.Rs1 != 0 {
+			csr.write(inst.Csr, old&^inst.Rs1)
+		}
+		reg[inst.Rd] = old
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Glorforidor/caeriscv/decode"
+)
+
+// historyLimit bounds how many snapshots the debugger keeps around for
+// rstep. Each snapshot copies the whole ram image behind the bus, not just
+// the words a step touched.
+const historyLimit = 1024
+
+// snapshot captures a cpu's pc, registers and ram image. CSRs, the
+// syscall state and mmio device state are not captured, so rstep does not
+// roll them back.
+type snapshot struct {
+	pc  uint32
+	reg [32]uint32
+	mem []byte
+}
+
+// debugger drives a cpu interactively from a REPL, gdb-style: breakpoints
+// and watchpoints halt a run, and step/rstep move one instruction at a
+// time in either direction.
+type debugger struct {
+	c       *cpu
+	symbols map[string]uint32
+	prog    string
+
+	breakpoints map[uint32]bool
+	watchpoints map[uint32]uint32 // addr -> last known value
+	history     []snapshot
+
+	scan *bufio.Scanner
+	w    *tabwriter.Writer
+}
+
+// newDebugger returns a debugger attached to c. symbols may be nil, in
+// which case "break <symbol>" only ever matches numeric addresses.
+func newDebugger(c *cpu, symbols map[string]uint32, prog string) *debugger {
+	return &debugger{
+		c:           c,
+		symbols:     symbols,
+		prog:        prog,
+		breakpoints: make(map[uint32]bool),
+		watchpoints: make(map[uint32]uint32),
+		scan:        bufio.NewScanner(os.Stdin),
+		w:           tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight),
+	}
+}
+
+// run starts the REPL and returns once the user quits or the program
+// exits.
+func (d *debugger) run() {
+	fmt.Println("Welcome to the caeriscv debugger")
+	fmt.Printf("Running program: %s\n", d.prog)
+	fmt.Println(`Type "help" for a list of commands.`)
+
+	for {
+		fmt.Print("(caeriscv) ")
+		if !d.scan.Scan() {
+			return
+		}
+		fields := strings.Fields(d.scan.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "step", "s":
+			d.step(argCount(fields, 1))
+		case "rstep":
+			d.rstep(argCount(fields, 1))
+		case "continue", "c":
+			d.cont()
+		case "break", "b":
+			d.setBreak(fields[1:])
+		case "watch", "w":
+			d.setWatch(fields[1:])
+		case "info":
+			d.info(fields[1:])
+		case "disasm":
+			d.disasm(fields[1:])
+		case "help", "h":
+			d.help()
+		case "quit", "q", "exit":
+			return
+		default:
+			if strings.HasPrefix(fields[0], "x/") {
+				d.examine(fields)
+				break
+			}
+			fmt.Printf("unknown command: %s (try \"help\")\n", fields[0])
+		}
+	}
+}
+
+// argCount parses fields[i] as a repeat count, defaulting to 1.
+func argCount(fields []string, i int) int {
+	if i >= len(fields) {
+		return 1
+	}
+	n, err := strconv.Atoi(fields[i])
+	if err != nil || n < 1 {
+		return 1
+	}
+	return n
+}
+
+// step advances n instructions, snapshotting before each one so rstep can
+// undo it, and stops early on a breakpoint, watchpoint or program exit.
+func (d *debugger) step(n int) {
+	for i := 0; i < n; i++ {
+		d.snapshot()
+		exit := d.execOne()
+		if exit {
+			fmt.Println("program exited")
+			return
+		}
+		if d.hitWatch() || d.hitBreak() {
+			return
+		}
+	}
+}
+
+// rstep restores the cpu to its state n steps ago.
+func (d *debugger) rstep(n int) {
+	if n > len(d.history) {
+		n = len(d.history)
+	}
+	for i := 0; i < n; i++ {
+		s := d.history[len(d.history)-1]
+		d.history = d.history[:len(d.history)-1]
+		d.c.PC = s.pc
+		d.c.Reg = s.reg
+		copy(d.c.Mem.RAM(), s.mem)
+	}
+	fmt.Printf("pc=%#x\n", d.c.PC)
+}
+
+// cont runs until a breakpoint, watchpoint or program exit.
+func (d *debugger) cont() {
+	for {
+		d.snapshot()
+		if d.execOne() {
+			fmt.Println("program exited")
+			return
+		}
+		if d.hitWatch() || d.hitBreak() {
+			return
+		}
+	}
+}
+
+// execOne fetches, executes and retires the instruction at pc, reporting
+// whether the program exited.
+func (d *debugger) execOne() (exit bool) {
+	return step(d.c)
+}
+
+// snapshot records the cpu's current state, evicting the oldest entry once
+// history is full.
+func (d *debugger) snapshot() {
+	mem := make([]byte, len(d.c.Mem.RAM()))
+	copy(mem, d.c.Mem.RAM())
+	if len(d.history) >= historyLimit {
+		d.history = d.history[1:]
+	}
+	d.history = append(d.history, snapshot{pc: d.c.PC, reg: d.c.Reg, mem: mem})
+}
+
+// hitBreak reports (and announces) whether pc is a breakpoint.
+func (d *debugger) hitBreak() bool {
+	if d.breakpoints[d.c.PC] {
+		fmt.Printf("breakpoint hit at pc=%#x\n", d.c.PC)
+		return true
+	}
+	return false
+}
+
+// hitWatch reports (and announces) whether any watched address changed
+// value since the last check.
+func (d *debugger) hitWatch() bool {
+	hit := false
+	for addr, old := range d.watchpoints {
+		cur := d.c.Mem.Read32(addr)
+		if cur != old {
+			fmt.Printf("watchpoint %#x: %#x -> %#x\n", addr, old, cur)
+			d.watchpoints[addr] = cur
+			hit = true
+		}
+	}
+	return hit
+}
+
+// setBreak sets a breakpoint at a numeric address or a symbol name.
+func (d *debugger) setBreak(args []string) {
+	if len(args) < 1 {
+		fmt.Println("usage: break <addr|symbol>")
+		return
+	}
+	addr, ok := d.resolve(args[0])
+	if !ok {
+		fmt.Printf("unknown address or symbol: %s\n", args[0])
+		return
+	}
+	d.breakpoints[addr] = true
+	fmt.Printf("breakpoint set at pc=%#x\n", addr)
+}
+
+// setWatch sets a watchpoint on the word at a numeric address or symbol.
+func (d *debugger) setWatch(args []string) {
+	if len(args) < 1 {
+		fmt.Println("usage: watch <addr|symbol>")
+		return
+	}
+	addr, ok := d.resolve(args[0])
+	if !ok {
+		fmt.Printf("unknown address or symbol: %s\n", args[0])
+		return
+	}
+	d.watchpoints[addr] = d.c.Mem.Read32(addr)
+	fmt.Printf("watchpoint set at %#x (current value %#x)\n", addr, d.watchpoints[addr])
+}
+
+// resolve turns a symbol name or a "0x"-prefixed or decimal address string
+// into a byte address.
+func (d *debugger) resolve(s string) (uint32, bool) {
+	if addr, ok := d.symbols[s]; ok {
+		return addr, true
+	}
+	n, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(n), true
+}
+
+// info prints register state; "reg" (or "registers") is the only
+// subcommand.
+func (d *debugger) info(args []string) {
+	if len(args) < 1 {
+		fmt.Println("usage: info reg")
+		return
+	}
+	switch args[0] {
+	case "reg", "registers":
+		fmt.Fprintf(d.w, header, gen()...)
+		fmt.Fprintf(d.w, "%v\t", d.c.PC)
+		fmt.Fprintf(d.w, body, conv(d.c.Reg[:])...)
+		d.w.Flush()
+	default:
+		fmt.Printf("unknown info subcommand: %s\n", args[0])
+	}
+}
+
+// disasm disassembles count instructions (default 1) starting at addr
+// (default the current pc).
+func (d *debugger) disasm(args []string) {
+	addr := d.c.PC
+	if len(args) >= 1 {
+		a, ok := d.resolve(args[0])
+		if !ok {
+			fmt.Printf("unknown address or symbol: %s\n", args[0])
+			return
+		}
+		addr = a
+	}
+	count := argCount(args, 1)
+	for i := 0; i < count; i++ {
+		instr := d.c.Mem.Read32(addr)
+		fmt.Printf("%#08x:\t%s\n", addr, decode.Decode(instr))
+		addr += 4
+	}
+}
+
+// examine implements gdb's "x/<n><fmt> <addr>" memory examination, with
+// fmt one of x (hex), d (signed decimal), u (unsigned decimal), c (char)
+// or i (disassembly).
+func (d *debugger) examine(fields []string) {
+	spec := strings.TrimPrefix(fields[0], "x/")
+	n, format := parseExamineSpec(spec)
+
+	if len(fields) < 2 {
+		fmt.Println("usage: x/<n><fmt> <addr>")
+		return
+	}
+	addr, ok := d.resolve(fields[1])
+	if !ok {
+		fmt.Printf("unknown address or symbol: %s\n", fields[1])
+		return
+	}
+
+	if format == 'i' {
+		d.disasm([]string{fields[1], strconv.Itoa(n)})
+		return
+	}
+
+	for i := 0; i < n; i++ {
+		word := d.c.Mem.Read32(addr)
+		switch format {
+		case 'd':
+			fmt.Printf("%#08x:\t%d\n", addr, int32(word))
+		case 'u':
+			fmt.Printf("%#08x:\t%d\n", addr, word)
+		case 'c':
+			fmt.Printf("%#08x:\t%q\n", addr, rune(word&0xff))
+		default: // anything else falls back to hex, x being the gdb default
+			fmt.Printf("%#08x:\t%#08x\n", addr, word)
+		}
+		addr += 4
+	}
+}
+
+// parseExamineSpec splits an "x/<n><fmt>" spec such as "4x" or "i" into
+// its repeat count (default 1) and format letter (default 'x').
+func parseExamineSpec(spec string) (n int, format byte) {
+	n, format = 1, 'x'
+	i := 0
+	for i < len(spec) && spec[i] >= '0' && spec[i] <= '9' {
+		i++
+	}
+	if i > 0 {
+		if v, err := strconv.Atoi(spec[:i]); err == nil {
+			n = v
+		}
+	}
+	if i < len(spec) {
+		format = spec[i]
+	}
+	return n, format
+}
+
+func (d *debugger) help() {
+	fmt.Println(`Commands:
+  step [n], s [n]        execute n instructions (default 1)
+  rstep [n]              undo n instructions
+  continue, c            run until a breakpoint, watchpoint or exit
+  break <addr|sym>, b    set a breakpoint
+  watch <addr|sym>, w    set a watchpoint on a word
+  info reg               print all registers and the pc
+  disasm <addr> [n]      disassemble n instructions from addr
+  x/<n><fmt> <addr>      examine memory (fmt: x, d, u, c, i)
+  help, h                show this message
+  quit, q, exit          leave the debugger`)
+}
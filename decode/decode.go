@@ -0,0 +1,292 @@
+// Package decode turns a raw RV32I instruction word into a typed Inst value.
+//
+// Decoding is driven by a table of {mask, value, op, format} entries, the
+// same declarative approach used by the Go x/arch decoders (ppc64asm,
+// armasm): an instruction word matches an entry when instr&mask == value,
+// and the entry tells us both which operation it is and how to pull the
+// operand fields out of the word.
+package decode
+
+import "fmt"
+
+// Op identifies a decoded RISC-V operation.
+type Op int
+
+// Supported RV32I/M operations.
+const (
+	Invalid Op = iota
+	LB
+	LH
+	LW
+	LBU
+	LHU
+	ADDI
+	SLLI
+	SLTI
+	SLTIU
+	XORI
+	SRLI
+	SRAI
+	ORI
+	ANDI
+	AUIPC
+	SB
+	SH
+	SW
+	ADD
+	MUL
+	SUB
+	SLL
+	MULH
+	SLT
+	MULHSU
+	SLTU
+	MULHU
+	XOR
+	DIV
+	SRL
+	DIVU
+	SRA
+	OR
+	REM
+	AND
+	REMU
+	LUI
+	BEQ
+	BNE
+	BLT
+	BGE
+	BLTU
+	BGEU
+	JALR
+	JAL
+	ECALL
+	EBREAK
+	CSRRW
+	CSRRS
+	CSRRC
+	CSRRWI
+	CSRRSI
+	CSRRCI
+	MRET
+)
+
+var opNames = map[Op]string{
+	Invalid: "invalid",
+	LB:      "lb", LH: "lh", LW: "lw", LBU: "lbu", LHU: "lhu",
+	ADDI: "addi", SLLI: "slli", SLTI: "slti", SLTIU: "sltiu",
+	XORI: "xori", SRLI: "srli", SRAI: "srai", ORI: "ori", ANDI: "andi",
+	AUIPC: "auipc",
+	SB:    "sb", SH: "sh", SW: "sw",
+	ADD: "add", MUL: "mul", SUB: "sub", SLL: "sll", MULH: "mulh",
+	SLT: "slt", MULHSU: "mulhsu", SLTU: "sltu", MULHU: "mulhu",
+	XOR: "xor", DIV: "div", SRL: "srl", DIVU: "divu", SRA: "sra",
+	OR: "or", REM: "rem", AND: "and", REMU: "remu",
+	LUI:  "lui",
+	BEQ:  "beq", BNE: "bne", BLT: "blt", BGE: "bge", BLTU: "bltu", BGEU: "bgeu",
+	JALR: "jalr", JAL: "jal", ECALL: "ecall", EBREAK: "ebreak",
+	CSRRW: "csrrw", CSRRS: "csrrs", CSRRC: "csrrc",
+	CSRRWI: "csrrwi", CSRRSI: "csrrsi", CSRRCI: "csrrci",
+	MRET: "mret",
+}
+
+// String returns the assembly mnemonic for op, used for disassembly.
+func (op Op) String() string {
+	if name, ok := opNames[op]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// format describes which operand fields a raw instruction word carries.
+type format int
+
+const (
+	rFormat format = iota
+	iFormat
+	sFormat
+	bFormat
+	uFormat
+	jFormat
+	csrFormat
+)
+
+// Inst is a decoded instruction: an Op plus its typed operand fields. Imm
+// is already sign-extended, so callers never touch raw bit fields again.
+//
+// For the CSR ops, Csr holds the (unsigned, unextended) CSR address and
+// Rs1 doubles as the 5-bit zero-extended immediate on the *I variants,
+// exactly as the encoding reuses the rs1 field for it.
+type Inst struct {
+	Op  Op
+	Rd  uint32
+	Rs1 uint32
+	Rs2 uint32
+	Imm int32
+	Csr uint32
+}
+
+// abiNames are the calling-convention register names, used for
+// disassembly instead of the bare x0-x31 numbers.
+var abiNames = [32]string{
+	"zero", "ra", "sp", "gp", "tp", "t0", "t1", "t2",
+	"s0", "s1", "a0", "a1", "a2", "a3", "a4", "a5",
+	"a6", "a7", "s2", "s3", "s4", "s5", "s6", "s7",
+	"s8", "s9", "s10", "s11", "t3", "t4", "t5", "t6",
+}
+
+// RegName returns the ABI name (ra, sp, a0, ...) for register number r.
+func RegName(r uint32) string {
+	return abiNames[r&0x1f]
+}
+
+// String disassembles inst into RISC-V assembly syntax, using immediates
+// relative to the instruction rather than resolving branch/jump targets,
+// which callers with a pc can do themselves.
+func (i Inst) String() string {
+	switch i.Op {
+	case Invalid:
+		return "invalid"
+	case LB, LH, LW, LBU, LHU:
+		return fmt.Sprintf("%s %s, %d(%s)", i.Op, RegName(i.Rd), i.Imm, RegName(i.Rs1))
+	case SB, SH, SW:
+		return fmt.Sprintf("%s %s, %d(%s)", i.Op, RegName(i.Rs2), i.Imm, RegName(i.Rs1))
+	case SLLI, SRLI, SRAI:
+		return fmt.Sprintf("%s %s, %s, %d", i.Op, RegName(i.Rd), RegName(i.Rs1), i.Imm&0x1f)
+	case ADDI, SLTI, SLTIU, XORI, ORI, ANDI, JALR:
+		return fmt.Sprintf("%s %s, %s, %d", i.Op, RegName(i.Rd), RegName(i.Rs1), i.Imm)
+	case AUIPC, LUI:
+		return fmt.Sprintf("%s %s, %#x", i.Op, RegName(i.Rd), uint32(i.Imm)>>12)
+	case ADD, SUB, SLL, SLT, SLTU, XOR, SRL, SRA, OR, AND,
+		MUL, MULH, MULHSU, MULHU, DIV, DIVU, REM, REMU:
+		return fmt.Sprintf("%s %s, %s, %s", i.Op, RegName(i.Rd), RegName(i.Rs1), RegName(i.Rs2))
+	case BEQ, BNE, BLT, BGE, BLTU, BGEU:
+		return fmt.Sprintf("%s %s, %s, %d", i.Op, RegName(i.Rs1), RegName(i.Rs2), i.Imm)
+	case JAL:
+		return fmt.Sprintf("%s %s, %d", i.Op, RegName(i.Rd), i.Imm)
+	case ECALL, EBREAK, MRET:
+		return i.Op.String()
+	case CSRRW, CSRRS, CSRRC:
+		return fmt.Sprintf("%s %s, %#x, %s", i.Op, RegName(i.Rd), i.Csr, RegName(i.Rs1))
+	case CSRRWI, CSRRSI, CSRRCI:
+		return fmt.Sprintf("%s %s, %#x, %d", i.Op, RegName(i.Rd), i.Csr, i.Rs1)
+	default:
+		return "unknown"
+	}
+}
+
+// instFormat is one entry of the decode table: instr matches when
+// instr&mask == value.
+type instFormat struct {
+	mask  uint32
+	value uint32
+	op    Op
+	fmt   format
+}
+
+var table = []instFormat{
+	{0x0000707f, 0x00000003, LB, iFormat},
+	{0x0000707f, 0x00001003, LH, iFormat},
+	{0x0000707f, 0x00002003, LW, iFormat},
+	{0x0000707f, 0x00004003, LBU, iFormat},
+	{0x0000707f, 0x00005003, LHU, iFormat},
+
+	{0x0000707f, 0x00000013, ADDI, iFormat},
+	{0xfe00707f, 0x00001013, SLLI, iFormat},
+	{0x0000707f, 0x00002013, SLTI, iFormat},
+	{0x0000707f, 0x00003013, SLTIU, iFormat},
+	{0x0000707f, 0x00004013, XORI, iFormat},
+	{0xfe00707f, 0x00005013, SRLI, iFormat},
+	{0xfe00707f, 0x40005013, SRAI, iFormat},
+	{0x0000707f, 0x00006013, ORI, iFormat},
+	{0x0000707f, 0x00007013, ANDI, iFormat},
+
+	{0x0000007f, 0x00000017, AUIPC, uFormat},
+
+	{0x0000707f, 0x00000023, SB, sFormat},
+	{0x0000707f, 0x00001023, SH, sFormat},
+	{0x0000707f, 0x00002023, SW, sFormat},
+
+	{0xfe00707f, 0x00000033, ADD, rFormat},
+	{0xfe00707f, 0x02000033, MUL, rFormat},
+	{0xfe00707f, 0x40000033, SUB, rFormat},
+	{0xfe00707f, 0x00001033, SLL, rFormat},
+	{0xfe00707f, 0x02001033, MULH, rFormat},
+	{0xfe00707f, 0x00002033, SLT, rFormat},
+	{0xfe00707f, 0x02002033, MULHSU, rFormat},
+	{0xfe00707f, 0x00003033, SLTU, rFormat},
+	{0xfe00707f, 0x02003033, MULHU, rFormat},
+	{0xfe00707f, 0x00004033, XOR, rFormat},
+	{0xfe00707f, 0x02004033, DIV, rFormat},
+	{0xfe00707f, 0x00005033, SRL, rFormat},
+	{0xfe00707f, 0x02005033, DIVU, rFormat},
+	{0xfe00707f, 0x40005033, SRA, rFormat},
+	{0xfe00707f, 0x00006033, OR, rFormat},
+	{0xfe00707f, 0x02006033, REM, rFormat},
+	{0xfe00707f, 0x00007033, AND, rFormat},
+	{0xfe00707f, 0x02007033, REMU, rFormat},
+
+	{0x0000007f, 0x00000037, LUI, uFormat},
+
+	{0x0000707f, 0x00000063, BEQ, bFormat},
+	{0x0000707f, 0x00001063, BNE, bFormat},
+	{0x0000707f, 0x00004063, BLT, bFormat},
+	{0x0000707f, 0x00005063, BGE, bFormat},
+	{0x0000707f, 0x00006063, BLTU, bFormat},
+	{0x0000707f, 0x00007063, BGEU, bFormat},
+
+	{0x0000707f, 0x00000067, JALR, iFormat},
+
+	{0x0000007f, 0x0000006f, JAL, jFormat},
+
+	{0xffffffff, 0x00000073, ECALL, iFormat},
+	{0xffffffff, 0x00100073, EBREAK, iFormat},
+	{0xffffffff, 0x30200073, MRET, iFormat},
+
+	{0x0000707f, 0x00001073, CSRRW, csrFormat},
+	{0x0000707f, 0x00002073, CSRRS, csrFormat},
+	{0x0000707f, 0x00003073, CSRRC, csrFormat},
+	{0x0000707f, 0x00005073, CSRRWI, csrFormat},
+	{0x0000707f, 0x00006073, CSRRSI, csrFormat},
+	{0x0000707f, 0x00007073, CSRRCI, csrFormat},
+}
+
+// Decode turns a raw instruction word into an Inst. An instruction word
+// that matches no table entry decodes to Op Invalid.
+func Decode(instr uint32) Inst {
+	for _, f := range table {
+		if instr&f.mask == f.value {
+			return build(instr, f.op, f.fmt)
+		}
+	}
+	return Inst{Op: Invalid}
+}
+
+func build(instr uint32, op Op, f format) Inst {
+	inst := Inst{
+		Op:  op,
+		Rd:  (instr >> 7) & 0x1f,
+		Rs1: (instr >> 15) & 0x1f,
+		Rs2: (instr >> 20) & 0x1f,
+	}
+
+	switch f {
+	case iFormat:
+		inst.Imm = int32(instr) >> 20
+	case sFormat:
+		imm := int32(instr)>>25<<5 | int32((instr>>7)&0x1f)
+		inst.Imm = imm
+	case bFormat:
+		raw := (instr>>31)&0x1<<12 | (instr>>7)&0x1<<11 | (instr>>25)&0x3f<<5 | (instr>>8)&0xf<<1
+		inst.Imm = int32(raw<<19) >> 19
+	case uFormat:
+		inst.Imm = int32(instr & 0xfffff000)
+	case jFormat:
+		raw := (instr>>31)&0x1<<20 | (instr>>12)&0xff<<12 | (instr>>20)&0x1<<11 | (instr>>21)&0x3ff<<1
+		inst.Imm = int32(raw<<11) >> 11
+	case csrFormat:
+		inst.Csr = (instr >> 20) & 0xfff
+	}
+
+	return inst
+}
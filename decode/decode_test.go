@@ -0,0 +1,46 @@
+package decode
+
+import "testing"
+
+func TestDecodeIFormatSignExtendsImm(t *testing.T) {
+	// addi x1, x2, -1
+	instr := uint32(0xfff10093)
+	got := Decode(instr)
+	if got.Op != ADDI || got.Rd != 1 || got.Rs1 != 2 || got.Imm != -1 {
+		t.Errorf("Decode(%#x) = %+v, want {Op:ADDI Rd:1 Rs1:2 Imm:-1}", instr, got)
+	}
+}
+
+func TestDecodeBFormatSignExtendsImm(t *testing.T) {
+	// beq x1, x2, -4
+	instr := uint32(0xfe208ee3)
+	got := Decode(instr)
+	if got.Op != BEQ || got.Rs1 != 1 || got.Rs2 != 2 || got.Imm != -4 {
+		t.Errorf("Decode(%#x) = %+v, want {Op:BEQ Rs1:1 Rs2:2 Imm:-4}", instr, got)
+	}
+}
+
+func TestDecodeJFormatSignExtendsImm(t *testing.T) {
+	// jal x1, -4
+	instr := uint32(0xffdff0ef)
+	got := Decode(instr)
+	if got.Op != JAL || got.Rd != 1 || got.Imm != -4 {
+		t.Errorf("Decode(%#x) = %+v, want {Op:JAL Rd:1 Imm:-4}", instr, got)
+	}
+}
+
+func TestDecodeCSRFormatExtractsCsrField(t *testing.T) {
+	// csrrw x5, mstatus, x3
+	instr := uint32(0x300192f3)
+	got := Decode(instr)
+	if got.Op != CSRRW || got.Rd != 5 || got.Rs1 != 3 || got.Csr != 0x300 {
+		t.Errorf("Decode(%#x) = %+v, want {Op:CSRRW Rd:5 Rs1:3 Csr:0x300}", instr, got)
+	}
+}
+
+func TestDecodeUnknownOpcodeIsInvalid(t *testing.T) {
+	got := Decode(0xffffffff)
+	if got.Op != Invalid {
+		t.Errorf("Decode(0xffffffff).Op = %v, want Invalid", got.Op)
+	}
+}
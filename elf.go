@@ -0,0 +1,71 @@
+package main
+
+import (
+	"debug/elf"
+	"fmt"
+)
+
+// elfMagic is the 4-byte magic number every ELF file starts with.
+var elfMagic = []byte{0x7f, 'E', 'L', 'F'}
+
+// isELF reports whether b starts with the ELF magic number.
+func isELF(b []byte) bool {
+	return len(b) >= len(elfMagic) && string(b[:len(elfMagic)]) == string(elfMagic)
+}
+
+// stackSize is the room left above the highest loaded segment for the
+// stack to grow into.
+const stackSize = 4096
+
+// readELF loads a RISC-V ELF32 executable, placing every PT_LOAD segment
+// at its p_vaddr inside a freshly allocated memory image. It returns the
+// image, the entry point taken from e_entry, the initial stack pointer
+// (set to the top of the loaded image), and a name-to-address map of the
+// symbol table, for the debugger's "break <symbol>" command.
+func readELF(name string) (mem []byte, entry, sp uint32, symbols map[string]uint32, err error) {
+	f, err := elf.Open(name)
+	if err != nil {
+		return nil, 0, 0, nil, fmt.Errorf("could not open elf file: %v", err)
+	}
+	defer f.Close()
+
+	if f.Class != elf.ELFCLASS32 {
+		return nil, 0, 0, nil, fmt.Errorf("unsupported elf class: %v, want ELFCLASS32", f.Class)
+	}
+	if f.Machine != elf.EM_RISCV {
+		return nil, 0, 0, nil, fmt.Errorf("unsupported elf machine: %v, want EM_RISCV", f.Machine)
+	}
+
+	var top uint64
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_LOAD {
+			continue
+		}
+		if end := prog.Vaddr + prog.Memsz; end > top {
+			top = end
+		}
+	}
+
+	mem = make([]byte, top+stackSize)
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_LOAD {
+			continue
+		}
+		data := make([]byte, prog.Filesz)
+		if _, err := prog.ReadAt(data, 0); err != nil {
+			return nil, 0, 0, nil, fmt.Errorf("could not read segment: %v", err)
+		}
+		copy(mem[prog.Vaddr:], data)
+	}
+
+	symbols = make(map[string]uint32)
+	if syms, err := f.Symbols(); err == nil {
+		for _, s := range syms {
+			if s.Name != "" {
+				symbols[s.Name] = uint32(s.Value)
+			}
+		}
+	}
+
+	return mem, uint32(f.Entry), uint32(len(mem)), symbols, nil
+}
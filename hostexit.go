@@ -0,0 +1,36 @@
+package main
+
+// hostExit is a single-word MMIO device a test program writes its exit
+// status to, following the riscv-tests/spike HTIF convention of ending a
+// bare-metal program with a memory-mapped store instead of an ecall.
+const (
+	hostExitBase = 0x40000000
+	hostExitSize = 4
+)
+
+type hostExit struct {
+	pending bool
+	status  int32
+}
+
+func newHostExit() *hostExit {
+	return &hostExit{}
+}
+
+func (h *hostExit) Read32(addr uint32) uint32 { return uint32(h.status) }
+
+// Write32 decodes val the way spike and the riscv-tests harness do: bit 0
+// requests a halt, and the remaining bits are the exit code shifted left
+// by one, so a passing test (tohost = 1) reports status 0 rather than 1.
+func (h *hostExit) Write32(addr uint32, val uint32) {
+	if val&1 == 0 {
+		return
+	}
+	h.pending = true
+	h.status = int32(val) >> 1
+}
+
+func (h *hostExit) Read8(addr uint32) uint8         { return uint8(h.Read32(addr)) }
+func (h *hostExit) Read16(addr uint32) uint16       { return uint16(h.Read32(addr)) }
+func (h *hostExit) Write8(addr uint32, val uint8)   { h.Write32(addr, uint32(val)) }
+func (h *hostExit) Write16(addr uint32, val uint16) { h.Write32(addr, uint32(val)) }
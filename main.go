@@ -13,7 +13,6 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
-	"text/tabwriter"
 )
 
 // readBinary reads binary file in little endian format and returns the content
@@ -75,348 +74,112 @@ func gen() []interface{} {
 	return v
 }
 
-// sext sign extend a imm value.
-func sext(imm uint32) uint32 {
-	if imm>>20 == 1 {
-		imm = imm | 0xfff00000
-	} else if imm>>11 == 1 {
-		imm = imm | 0xfffff000
+// store32 writes val as a little-endian word at the given byte address in
+// mem.
+func store32(mem []byte, addr, val uint32) {
+	for i := 0; i < 4; i++ {
+		mem[addr+uint32(i)] = byte(val >> uint(8*i))
 	}
-	return imm
-}
-
-// execute decode and executes the instruction and store the results into the
-// registers. It will return whether a branch instruction is taken with an
-// offset.
-func execute(pc, instr uint32, reg []uint32, mem []byte) (offset int, branching, exit bool) {
-	opcode := instr & 0x7f
-	switch opcode {
-	case 0x3:
-		rd := (instr >> 7) & 0x1f
-		funct3 := (instr >> 12) & 0x7
-		rs1 := (instr >> 15) & 0x1f
-		imm := sext((instr >> 20))
-		sp := reg[rs1]
-		switch funct3 {
-		case 0: // LB
-			reg[rd] = uint32(int8(mem[sp+imm]))
-		case 1: // LH
-			res := uint32(0)
-			for i := 0; i < 2; i++ {
-				res = res + uint32(int16(mem[sp+imm+uint32(i)])<<uint(8*i))
-			}
-			reg[rd] = res
-		case 2: // LW
-			res := uint32(0)
-			for i := 0; i < 4; i++ {
-				res = res + uint32(int32(mem[sp+imm+uint32(i)])<<uint(8*i))
-			}
-			reg[rd] = res
-		case 4: // LBU
-			reg[rd] = uint32(mem[sp+imm])
-		case 5: // LHU
-			res := uint32(0)
-			for i := 0; i < 2; i++ {
-				res = res + uint32(uint16(mem[sp+imm+uint32(i)])<<uint(8*i))
-			}
-			reg[rd] = res
-		}
-	case 0x13:
-		rd := (instr >> 7) & 0x1f
-		funct3 := (instr >> 12) & 0x7
-		rs1 := (instr >> 15) & 0x1f
-		imm := sext((instr >> 20))
-		switch funct3 {
-		case 0: // Addi
-			reg[rd] = reg[rs1] + imm
-		case 1: // Shift Left Logical Intermediate
-			shamt := imm & 0x3f
-			rest := (imm >> 6)
-			if rest == 0 {
-				reg[rd] = reg[rs1] << shamt
-			}
-		case 2: // SLTI
-			trs1 := int32(reg[rs1])
-			timm := int32(imm)
-			if trs1 < timm {
-				reg[rd] = 1
-			} else {
-				reg[rd] = 0
-			}
-		case 3: // SLTIU
-			if reg[rs1] < imm {
-				reg[rd] = 1
-			} else {
-				reg[rd] = 0
-			}
-		case 4: // XOR Intermediate
-			reg[rd] = reg[rs1] ^ imm
-		case 5: // Shift Right Intermediate
-			shamt := imm & 0x3f
-			rest := (imm >> 6)
-
-			if rest == 0 { // Logical
-				reg[rd] = reg[rs1] >> shamt
-			} else { // Arithmetic
-				reg[rd] = uint32(int32(reg[rs1]) >> shamt)
-			}
-		case 6: // OR Intermediate
-			reg[rd] = reg[rs1] | imm
-		case 7: // AND Intermediate
-			reg[rd] = reg[rs1] & imm
-		}
-	case 0x17: // AUIPC
-		rd := (instr >> 7) & 0x1f
-		imm := (instr >> 12) << 12
-		reg[rd] = pc + imm
-	case 0x23:
-		imm1 := (instr >> 7) & 0x1f
-		funct3 := (instr >> 12) & 0x7
-		rs1 := (instr >> 15) & 0x1f // base
-		rs2 := (instr >> 20) & 0x1f // src
-		imm2 := (instr >> 25)
-		imm := sext(imm2<<5 + imm1)
-		sp := reg[rs1]
-		switch funct3 {
-		case 0: // SB
-			mem[sp+imm] = byte(reg[rs2] & 0xff)
-		case 1: // SH
-			for i := 0; i < 2; i++ {
-				mem[sp+imm+uint32(i)] = byte((uint16(reg[rs2]) >> uint(8*i)) & 0xff)
-			}
-		case 2: // SW
-			for i := 0; i < 4; i++ {
-				mem[sp+imm+uint32(i)] = byte((uint32(reg[rs2]) >> uint(8*i)) & 0xff)
-			}
-		}
-	case 0x33:
-		rd := (instr >> 7) & 0x1f
-		funct3 := (instr >> 12) & 0x7
-		rs1 := (instr >> 15) & 0x1f
-		rs2 := (instr >> 20) & 0x1f
-		funct7 := (instr >> 25)
-		switch funct3 {
-		case 0:
-			switch funct7 {
-			case 0: // Add
-				reg[rd] = reg[rs1] + reg[rs2]
-			case 1: // Mul
-				reg[rd] = reg[rs1] * reg[rs2]
-			case 32: // Sub
-				reg[rd] = reg[rs1] - reg[rs2]
-			}
-		case 1:
-			switch funct7 {
-			case 0: // Shift Left Logical
-				reg[rd] = reg[rs1] << reg[rs2]
-			case 1: // Mulh
-				res := int64(int32(reg[rs1])) * int64(int32(reg[rs2]))
-				res = res >> 32
-				reg[rd] = uint32(res)
-			}
-		case 2:
-			switch funct7 {
-			case 0: // SLT
-				trs1 := int32(reg[rs1])
-				trs2 := int32(reg[rs2])
-				if trs1 < trs2 {
-					reg[rd] = 1
-				} else {
-					reg[rd] = 0
-				}
-			case 1: // Mulhsu
-				res := int64(int32(reg[rs1])) * int64(reg[rs2])
-				res = res >> 32
-				reg[rd] = uint32(res)
-			}
-		case 3:
-			switch funct7 {
-			case 0: // SLTU
-				if reg[rs1] < reg[rs2] {
-					reg[rd] = 1
-				} else {
-					reg[rd] = 0
-				}
-			case 1: // Mulhu
-				res := uint64(reg[rs1]) * uint64(reg[rs2])
-				res = res >> 32
-				reg[rd] = uint32(res)
-			}
-		case 4:
-			switch funct7 {
-			case 0: // XOR
-				reg[rd] = reg[rs1] ^ reg[rs2]
-			case 1: // Div
-				if int32(reg[rs2]) == 0 {
-					reg[rd] = ^uint32(0)
-				} else {
-					reg[rd] = uint32(int32(reg[rs1]) / int32(reg[rs2]))
-				}
-			}
-		case 5: // Shift Right
-			switch funct7 {
-			case 0: // Logical
-				reg[rd] = reg[rs1] >> reg[rs2]
-			case 1: // Divu
-				// TODO: ask TA about unsigned division by zero.
-				if reg[rs2] == 0 {
-					reg[rd] = reg[rs1]
-				} else {
-					reg[rd] = reg[rs1] / reg[rs2]
-				}
-			case 32: // Arithmetic
-				reg[rd] = uint32(int32(reg[rs1]) >> reg[rs2])
-			}
-		case 6:
-			switch funct7 {
-			case 0: // OR
-				reg[rd] = reg[rs1] | reg[rs2]
-			case 1: // Rem
-				if reg[rs2] == 0 {
-					reg[rd] = uint32(int32(reg[rs1]))
-				} else {
-					reg[rd] = uint32(int32(reg[rs1]) % int32(reg[rs2]))
-				}
-			}
-		case 7:
-			switch funct7 {
-			case 0: // AND
-				reg[rd] = reg[rs1] & reg[rs2]
-			case 1: // Remu
-				if reg[rs2] == 0 {
-					reg[rd] = reg[rs1]
-				} else {
-					reg[rd] = reg[rs1] % reg[rs2]
-				}
-			}
-		}
-	case 0x37: // LUI
-		rd := (instr >> 7) & 0x1f
-		imm := (instr >> 12) << 12
-		reg[rd] = imm
-	case 0x63: // Branching
-		imm1 := (instr >> 7) & 0x1 // imm 11
-		imm2 := (instr >> 8) & 0xf // imm 1 - 4
-		funct3 := (instr >> 12) & 0x7
-		rs1 := (instr >> 15) & 0x1f
-		rs2 := (instr >> 20) & 0x1f
-		imm3 := (instr >> 25) & 0x3f // imm 5 - 10
-		imm4 := (instr >> 31)        // imm 12
-		imm := imm4<<11 + imm1<<10 + imm3<<4 + imm2
-
-		if imm4 == 1 {
-			offset = -2 * int(imm^0xfff+1)
-		} else {
-			offset = 2 * int(imm)
-		}
-
-		switch funct3 {
-		case 0: // BEQ
-			branching = reg[rs1] == reg[rs2]
-		case 1: // BNE
-			branching = reg[rs1] != reg[rs2]
-		case 4: // BLT
-			branching = int32(reg[rs1]) < int32(reg[rs2])
-		case 5: // BGE
-			branching = int32(reg[rs1]) >= int32(reg[rs2])
-		case 6: // BLTU
-			branching = reg[rs1] < reg[rs2]
-		case 7: // BGEU
-			branching = reg[rs1] >= reg[rs2]
-		}
-	case 0x67: // JALR
-		rd := (instr >> 7) & 0x1f
-		funct3 := (instr >> 12) & 0x7
-		rs1 := (instr >> 15) & 0x1f
-		imm := sext((instr >> 20))
-		if funct3 == 0 {
-			branching = true
-			reg[rd] = pc + 1
-			offset = int(reg[rs1]+imm) & 0xfffffffe
-		}
-	case 0x6f: // JAL
-		rd := (instr >> 7) & 0x1f
-		imm1 := (instr >> 12) & 0xff  // imm 12 - 19
-		imm2 := (instr >> 20) & 0x1   // imm 11
-		imm3 := (instr >> 21) & 0x3ff // imm 1 - 10
-		imm4 := (instr >> 31)         // imm 20
-		imm := sext((imm4<<20 + imm1<<12 + imm2<<11 + imm3<<1))
-		branching = true
-		reg[rd] = pc + 1
-		offset = int(reg[rd] + imm)
-	case 0x73: // Ecall
-		fmt.Println(conv(reg)...)
-		exit = true
-	default:
-		fmt.Printf("Opcode %d not yet implemented\n", opcode)
-	}
-
-	reg[0] = 0
-
-	return offset, branching, exit
 }
 
 func usage() {
-	fmt.Println(`Usage: caeriscv [-debug] <binary file>`)
+	fmt.Println(`Usage: caeriscv [-debug] <binary file | ELF executable>`)
 	flag.PrintDefaults()
 }
 
+// binMemSize is the size of the memory image built for a raw .bin program:
+// enough room for a small program plus a stack growing down from the top.
+const binMemSize = 1 << 16
+
 func main() {
-	debug := flag.Bool("debug", false, "enable debug information")
+	debug := flag.Bool("debug", false, "run under the interactive debugger instead of to completion")
+	base := flag.Uint("base", 0, "base address to load a raw .bin program at")
 	flag.Usage = usage
 	flag.Parse()
 
 	args := flag.Args()
-	if len(args) < 1 || !strings.HasSuffix(args[0], ".bin") {
+	if len(args) < 1 {
 		usage()
 		os.Exit(1)
 	}
 
-	reg := make([]uint32, 32)
-	mem := make([]byte, 4096)
-	reg[2] = uint32(len(mem))
-	prog, err := readBinary(args[0])
+	magic, err := ioutil.ReadFile(args[0])
 	if err != nil {
 		panic(err)
 	}
 
-	w := new(tabwriter.Writer)
-	if *debug {
-		w.Init(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
-		fmt.Fprintln(w, "Welcome to Go RISC-V simulator")
-		fmt.Fprintf(w, "Running program: %s\n", filepath.Base(args[0]))
-		fmt.Fprintln(w, "Instructions:")
+	var mem []byte
+	var pc, sp uint32
+	var symbols map[string]uint32
+
+	switch {
+	case isELF(magic):
+		mem, pc, sp, symbols, err = readELF(args[0])
+		if err != nil {
+			panic(err)
+		}
+	case strings.HasSuffix(args[0], ".bin"):
+		prog, err := readBinary(args[0])
+		if err != nil {
+			panic(err)
+		}
+		pc = uint32(*base)
+		mem = make([]byte, binMemSize)
 		for i, instr := range prog {
-			fmt.Fprintf(w, "%d: %v\n", i, instr)
+			store32(mem, pc+uint32(i*4), instr)
 		}
-		fmt.Fprintln(w)
-		fmt.Fprintf(w, header, gen()...)
+		sp = uint32(len(mem))
+	default:
+		usage()
+		os.Exit(1)
+	}
+
+	c := newCPU(mem, pc, sp)
+
+	if *debug {
+		newDebugger(c, symbols, filepath.Base(args[0])).run()
+	} else {
+		run(c)
 	}
 
-	pc := uint32(0)
+	err = writeBinary("out.res", c.Reg[:])
+	if err != nil {
+		panic(err)
+	}
+}
+
+// run drives c's fetch/execute loop to completion, with no debugging
+// facilities attached.
+func run(c *cpu) {
 	for {
-		instr := prog[pc]
-		offset, branching, exit := execute(pc, instr, reg, mem)
-		if *debug {
-			fmt.Fprintf(w, "%v\t", pc)
-			fmt.Fprintf(w, body, conv(reg)...)
-		}
-		if exit {
+		if step(c) {
 			break
 		}
-		if branching {
-			pc = pc + uint32((offset / 4))
-			continue
-		}
+	}
+}
 
-		pc++
-		if pc >= uint32(len(prog)) {
-			break
-		}
+// step fetches, executes and retires a single instruction, advancing c.PC.
+// It reports whether the program exited.
+func step(c *cpu) (exit bool) {
+	c.timer.tick()
+	if offset, taken := c.checkTimerInterrupt(); taken {
+		c.PC = uint32(int(c.PC) + offset)
+		return false
 	}
-	w.Flush()
-	err = writeBinary("out.res", reg)
-	if err != nil {
-		panic(err)
+
+	instr := c.Mem.Read32(c.PC)
+	offset, branching, exit := c.execute(instr)
+	c.CSR.retire()
+	if c.exit.pending {
+		os.Exit(int(c.exit.status))
+	}
+	if exit {
+		return true
+	}
+	if branching {
+		c.PC = uint32(int(c.PC) + offset)
+	} else {
+		c.PC += 4
 	}
+	return int(c.PC) >= c.Mem.Len()
 }
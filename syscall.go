@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+)
+
+// Venus-compatible syscall numbers, keyed on a7 (x17). These match the
+// ones documented by the venus simulator so existing venus assembly test
+// programs run against this simulator unmodified.
+const (
+	sysPrintInt    = 1
+	sysPrintString = 4
+	sysReadInt     = 5
+	sysPrintChar   = 11
+	sysReadString  = 8
+	sysSbrk        = 9
+	sysExit        = 10
+	sysExit2       = 17
+	sysOpen        = 1024
+	sysRead        = 63
+	sysWrite       = 64
+	sysClose       = 57
+)
+
+var stdin = bufio.NewReader(os.Stdin)
+
+// syscall services the environment call currently in a7, using a0-a2 as
+// arguments and a0 as the return value, per the venus ABI. It reports
+// whether the program requested termination.
+func (c *cpu) syscall() (exit bool) {
+	reg := &c.Reg
+	switch reg[17] {
+	case sysPrintInt:
+		fmt.Print(int32(reg[10]))
+	case sysPrintString:
+		fmt.Print(c.cString(reg[10]))
+	case sysPrintChar:
+		fmt.Print(string(rune(reg[10])))
+	case sysReadInt:
+		var v int32
+		fmt.Fscan(stdin, &v)
+		reg[10] = uint32(v)
+	case sysReadString:
+		c.readString(reg[10], reg[11])
+	case sysSbrk:
+		old := c.brk
+		c.brk += reg[10]
+		reg[10] = old
+	case sysExit:
+		return true
+	case sysExit2:
+		os.Exit(int(int32(reg[10])))
+	case sysOpen:
+		c.sysOpen(reg)
+	case sysRead:
+		c.sysRead(reg)
+	case sysWrite:
+		c.sysWrite(reg)
+	case sysClose:
+		c.sysClose(reg)
+	default:
+		fmt.Printf("unimplemented syscall a7=%d\n", reg[17])
+	}
+	return false
+}
+
+// cString reads a NUL-terminated string out of memory starting at addr.
+func (c *cpu) cString(addr uint32) string {
+	mem := c.Mem.RAM()
+	end := addr
+	for mem[end] != 0 {
+		end++
+	}
+	return string(mem[addr:end])
+}
+
+// readString reads a line from stdin into mem at addr, NUL-terminated and
+// truncated to at most maxLen-1 bytes, matching the venus ReadString ABI.
+func (c *cpu) readString(addr, maxLen uint32) {
+	if maxLen == 0 {
+		return
+	}
+	line, _ := stdin.ReadString('\n')
+	if n := uint32(len(line)); n > maxLen-1 {
+		line = line[:maxLen-1]
+	}
+	mem := c.Mem.RAM()
+	copy(mem[addr:], line)
+	mem[addr+uint32(len(line))] = 0
+}
+
+// sysOpen implements the venus Open syscall: a0 is the path, a1 the
+// flags, a2 the mode; the new fd (or -1 on error) is returned in a0.
+func (c *cpu) sysOpen(reg *[32]uint32) {
+	path := c.cString(reg[10])
+	f, err := os.OpenFile(path, int(reg[11]), os.FileMode(reg[12]))
+	if err != nil {
+		reg[10] = ^uint32(0)
+		return
+	}
+	fd := c.nextFd
+	c.nextFd++
+	c.files[fd] = f
+	reg[10] = fd
+}
+
+// sysRead implements the venus Read syscall: a0 is the fd, a1 the buffer
+// address, a2 the length; the byte count read (or -1 on error) is
+// returned in a0.
+func (c *cpu) sysRead(reg *[32]uint32) {
+	f, ok := c.files[reg[10]]
+	if !ok {
+		reg[10] = ^uint32(0)
+		return
+	}
+	buf := make([]byte, reg[12])
+	n, err := f.Read(buf)
+	if err != nil && n == 0 {
+		reg[10] = ^uint32(0)
+		return
+	}
+	copy(c.Mem.RAM()[reg[11]:], buf[:n])
+	reg[10] = uint32(n)
+}
+
+// sysWrite implements the venus Write syscall: a0 is the fd, a1 the
+// buffer address, a2 the length; the byte count written (or -1 on error)
+// is returned in a0.
+func (c *cpu) sysWrite(reg *[32]uint32) {
+	f, ok := c.files[reg[10]]
+	if !ok {
+		reg[10] = ^uint32(0)
+		return
+	}
+	n, err := f.Write(c.Mem.RAM()[reg[11] : reg[11]+reg[12]])
+	if err != nil {
+		reg[10] = ^uint32(0)
+		return
+	}
+	reg[10] = uint32(n)
+}
+
+// sysClose implements the venus Close syscall: a0 is the fd.
+func (c *cpu) sysClose(reg *[32]uint32) {
+	f, ok := c.files[reg[10]]
+	if !ok {
+		reg[10] = ^uint32(0)
+		return
+	}
+	if f == os.Stdin || f == os.Stdout || f == os.Stderr {
+		return
+	}
+	f.Close()
+	delete(c.files, reg[10])
+}
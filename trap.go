@@ -0,0 +1,89 @@
+package main
+
+// Machine-mode CSR addresses, as laid out by the RISC-V privileged spec.
+const (
+	csrMstatus  = 0x300
+	csrMie      = 0x304
+	csrMtvec    = 0x305
+	csrMscratch = 0x340
+	csrMepc     = 0x341
+	csrMcause   = 0x342
+	csrMtval    = 0x343
+	csrMip      = 0x344
+	csrCycle    = 0xc00
+	csrInstret  = 0xc02
+)
+
+// Trap causes written to mcause by cpu.trap, ecall and ebreak, matching the
+// mcause encoding for synchronous exceptions in machine mode.
+const (
+	causeInstrAddrMisaligned = 0
+	causeIllegalInstruction  = 2
+	causeBreakpoint          = 3
+	causeLoadAddrMisaligned  = 4
+	causeStoreAddrMisaligned = 6
+	causeEcallM              = 11
+)
+
+// causeMachineTimerInterrupt is the mcause code for the CLINT's timer
+// interrupt. Interrupts are told apart from synchronous exceptions by
+// mcauseInterruptBit, the top bit of mcause.
+const (
+	causeMachineTimerInterrupt = 7
+	mcauseInterruptBit         = 1 << 31
+)
+
+// mstatus.MIE (the global machine-mode interrupt enable), mstatus.MPIE
+// (the previous MIE, saved across a trap so mret can restore it), and the
+// matching bit in mie/mip for the machine timer interrupt (MTIE/MTIP).
+const (
+	mstatusMIEBit  = 1 << 3
+	mstatusMPIEBit = 1 << 7
+	mtimerBit      = 1 << 7
+)
+
+// csrFile holds the machine-mode control and status registers. cycle and
+// instret are counted separately from the general register map since
+// they advance on every retired instruction rather than being set
+// explicitly.
+type csrFile struct {
+	regs    map[uint32]uint32
+	cycle   uint64
+	instret uint64
+}
+
+// newCSRFile returns a csrFile with every register reset to zero.
+func newCSRFile() csrFile {
+	return csrFile{regs: make(map[uint32]uint32)}
+}
+
+// read returns the current value of the CSR at addr, or zero for an
+// unimplemented address.
+func (c *csrFile) read(addr uint32) uint32 {
+	switch addr {
+	case csrCycle:
+		return uint32(c.cycle)
+	case csrInstret:
+		return uint32(c.instret)
+	default:
+		return c.regs[addr]
+	}
+}
+
+// write sets the CSR at addr to val. Writes to the read-only counters are
+// ignored.
+func (c *csrFile) write(addr, val uint32) {
+	switch addr {
+	case csrCycle, csrInstret:
+		// read-only from software; advanced by retire().
+	default:
+		c.regs[addr] = val
+	}
+}
+
+// retire advances the instruction/cycle counters. It should be called
+// once per executed instruction.
+func (c *csrFile) retire() {
+	c.cycle++
+	c.instret++
+}
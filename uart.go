@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"os"
+)
+
+// UART base address and ns16550a-compatible register offsets. Only the
+// two registers a polling driver actually needs to talk to a terminal are
+// implemented.
+const (
+	uartBase = 0x10000000
+	uartSize = 0x100
+
+	uartRBR = 0x0
+	uartTHR = 0x0
+	uartLSR = 0x5
+)
+
+// Line status register bits.
+const (
+	lsrDataReady = 1 << 0
+	lsrTHREmpty  = 1 << 5
+)
+
+// uart is a minimal 16550-compatible serial port: writes to THR go to
+// stdout, and reads from RBR consume stdin one byte at a time.
+type uart struct {
+	in *bufio.Reader
+}
+
+func newUART() *uart {
+	return &uart{in: bufio.NewReader(os.Stdin)}
+}
+
+func (u *uart) Read8(addr uint32) uint8 {
+	switch addr {
+	case uartLSR:
+		// THR is always empty, since a write is serviced synchronously,
+		// and we report data as always ready; a driver polling RBR
+		// simply blocks on the read, matching an interactive terminal.
+		return lsrTHREmpty | lsrDataReady
+	case uartRBR:
+		b, err := u.in.ReadByte()
+		if err != nil {
+			return 0
+		}
+		return b
+	default:
+		return 0
+	}
+}
+
+func (u *uart) Write8(addr uint32, val uint8) {
+	if addr == uartTHR {
+		os.Stdout.Write([]byte{val})
+	}
+}
+
+func (u *uart) Read16(addr uint32) uint16       { return uint16(u.Read8(addr)) }
+func (u *uart) Read32(addr uint32) uint32       { return uint32(u.Read8(addr)) }
+func (u *uart) Write16(addr uint32, val uint16) { u.Write8(addr, uint8(val)) }
+func (u *uart) Write32(addr uint32, val uint32) { u.Write8(addr, uint8(val)) }